@@ -4,6 +4,7 @@ import os.path
 from cosalib.builds import Builds
 from cosalib.cmdlib import (
     load_json,
+    sha256sum_file,
     write_json)
 
 
@@ -12,26 +13,38 @@ class GenericBuildMeta(dict):
     GenericBuildMeta interacts with a builds meta.json
     """
 
-    def __init__(self, workdir=None, build='latest'):
-        builds = Builds(workdir)
-        if build != "latest":
-            if not builds.has(build):
-                raise Exception('Build was not found in builds.json')
+    def __init__(self, workdir=None, build='latest', path=None):
+        if path is not None:
+            self._meta_path = os.path.abspath(path)
         else:
-            build = builds.get_latest()
+            builds = Builds(workdir)
+            if build != "latest":
+                if not builds.has(build):
+                    raise ValueError(
+                        f'Build {build} was not found in builds.json')
+            elif builds.is_empty():
+                raise ValueError('No builds found in builds.json')
+            else:
+                build = builds.get_latest()
 
-        self._meta_path = os.path.join(
-            builds.get_build_dir(build), 'meta.json')
+            self._meta_path = os.path.join(
+                builds.get_build_dir(build), 'meta.json')
+        self._build_dir = os.path.abspath(os.path.dirname(self._meta_path))
         self.read()
 
     def read(self):
         """
         Read the meta.json file into this object instance.
+
+        :raises: ValueError
         """
         # Remove any current data
         self.clear()
         # Load the file
-        self.update(load_json(self._meta_path))
+        data = load_json(self._meta_path)
+        if not isinstance(data, dict):
+            raise ValueError('top level must be an object')
+        self.update(data)
 
     def write(self):
         """
@@ -81,6 +94,111 @@ class GenericBuildMeta(dict):
         if updated is False:
             raise Exception('Unable to set {key} to {value}')
 
+    def _in_build_dir(self, path):
+        """
+        Checks whether a path resolves to somewhere under the build directory.
+
+        :param path: Path, absolute or relative to the build directory
+        :type path: str
+        :returns: The normalized absolute path, or None if it's outside
+        :rtype: str
+        """
+        fullpath = os.path.normpath(os.path.join(self._build_dir, path))
+        if os.path.commonpath([fullpath, self._build_dir]) != self._build_dir:
+            return None
+        return fullpath
+
+    def register_artifact(self, name, path):
+        """
+        Records an artifact under images, computing its sha256 and size
+        from the file itself.
+
+        :param name: The artifact name, e.g. qemu
+        :type name: str
+        :param path: Path to the artifact, absolute or relative to the
+                     build directory
+        :type path: str
+        :raises: Exception
+        """
+        fullpath = self._in_build_dir(path)
+        if fullpath is None:
+            raise Exception(f'{path} is not in {self._build_dir}')
+        self.setdefault('images', {})[name] = {
+            'path': os.path.relpath(fullpath, self._build_dir),
+            'sha256': sha256sum_file(fullpath),
+            'size': os.path.getsize(fullpath),
+        }
+
+    def verify_artifacts(self):
+        """
+        Recomputes the sha256 and size of every artifact under images
+        and compares them against the recorded values.
+
+        :returns: A list of human readable mismatches
+        :rtype: list
+        """
+        images = dict.get(self, 'images', {})
+        if not isinstance(images, dict):
+            return ['images must be an object']
+        mismatches = []
+        for name, image in images.items():
+            if not isinstance(image, dict) or 'path' not in image:
+                mismatches.append(f'images.{name}: missing path')
+                continue
+            if not isinstance(image['path'], str):
+                mismatches.append(f'images.{name}: path must be a string')
+                continue
+            path = None
+            if not os.path.isabs(image['path']):
+                path = self._in_build_dir(image['path'])
+            if path is None:
+                mismatches.append(
+                    f'images.{name}: {image["path"]} is not relative to '
+                    'the build directory')
+                continue
+            if not os.path.isfile(path):
+                mismatches.append(
+                    f'images.{name}: {image["path"]} does not exist')
+                continue
+            if 'size' in image:
+                size = os.path.getsize(path)
+                # bool is a subclass of int, but never a valid size
+                if (not isinstance(image['size'], int) or
+                        isinstance(image['size'], bool)):
+                    mismatches.append(
+                        f'images.{name}: size must be an integer')
+                elif image['size'] != size:
+                    mismatches.append(
+                        f'images.{name}: size {image["size"]} does not '
+                        f'match {size} on disk')
+            if 'sha256' in image:
+                if not isinstance(image['sha256'], str):
+                    mismatches.append(
+                        f'images.{name}: sha256 must be a string')
+                elif image['sha256'] != sha256sum_file(path):
+                    mismatches.append(
+                        f'images.{name}: sha256 does not match '
+                        f'{image["path"]}')
+        return mismatches
+
+    def validate(self):
+        """
+        Checks the structure for invariants that must hold for a build.
+        All violations are collected rather than stopping at the first.
+        There is no schema to check against, so unknown fields are not
+        rejected.
+
+        :returns: A list of human readable violations
+        :rtype: list
+        """
+        errors = []
+        buildid = dict.get(self, 'buildid')
+        if not isinstance(buildid, str) or buildid == '':
+            errors.append('buildid must be a non-empty string')
+
+        errors.extend(self.verify_artifacts())
+        return errors
+
     def __str__(self):
         """
         Returns the entire structure in a pretty json string format.
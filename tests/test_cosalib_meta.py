@@ -1,3 +1,4 @@
+import hashlib
 import os
 import json
 import sys
@@ -80,3 +81,195 @@ def test_str(tmpdir):
     """
     m = meta.GenericBuildMeta(_create_test_files(tmpdir), '1.2.3')
     assert dict(m) == json.loads(str(m))
+
+
+def _create_validate_files(tmpdir, image_data=b'image'):
+    """
+    Creates a meta.json describing a single image artifact.
+    """
+    tmpdir = _create_test_files(tmpdir)
+    metadir = os.path.join(
+        tmpdir, 'builds', '1.2.3', get_basearch())
+    with open(os.path.join(metadir, 'test.qcow2'), 'wb') as f:
+        f.write(image_data)
+    data = {
+        'buildid': '1.2.3',
+        'images': {
+            'qemu': {
+                'path': 'test.qcow2',
+                'sha256': hashlib.sha256(b'image').hexdigest(),
+                'size': len(b'image'),
+            }
+        }
+    }
+    with open(os.path.join(metadir, 'meta.json'), 'w') as f:
+        f.write(json.dumps(data))
+    return tmpdir
+
+
+def test_validate(tmpdir):
+    """
+    Verify a consistent build validates cleanly.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    assert m.validate() == []
+
+
+def test_validate_path(tmpdir):
+    """
+    Verify a meta.json can be loaded and validated by path.
+    """
+    metapath = os.path.join(
+        _create_validate_files(tmpdir), 'builds', '1.2.3',
+        get_basearch(), 'meta.json')
+    m = meta.GenericBuildMeta(path=metapath)
+    assert m.validate() == []
+
+
+def test_validate_errors(tmpdir):
+    """
+    Verify all violations are reported, not just the first.
+    """
+    m = meta.GenericBuildMeta(
+        _create_validate_files(tmpdir, b'corrupt'), '1.2.3')
+    m.set('buildid', '')
+    m['images']['missing'] = {'path': 'missing.qcow2'}
+    errors = m.validate()
+    assert len(errors) == 4
+    assert 'buildid must be a non-empty string' in errors
+    assert 'images.missing: missing.qcow2 does not exist' in errors
+
+
+def test_validate_outside_build_dir(tmpdir):
+    """
+    Verify artifact paths must stay within the build directory.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    m['images']['up'] = {'path': '../../builds.json'}
+    m['images']['abs'] = {'path': '/etc/hostname'}
+    assert m.validate() == [
+        'images.up: ../../builds.json is not relative to the build directory',
+        'images.abs: /etc/hostname is not relative to the build directory',
+    ]
+
+
+def test_validate_types(tmpdir):
+    """
+    Verify mistyped size and sha256 values are reported as such.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    m['images']['qemu']['size'] = '5'
+    m['images']['qemu']['sha256'] = 5
+    assert m.validate() == [
+        'images.qemu: size must be an integer',
+        'images.qemu: sha256 must be a string',
+    ]
+
+
+def test_read_not_object(tmpdir):
+    """
+    Verify a meta.json whose top level is not an object is rejected.
+    """
+    metapath = os.path.join(
+        _create_test_files(tmpdir), 'builds', '1.2.3',
+        get_basearch(), 'meta.json')
+    with open(metapath, 'w') as f:
+        f.write(json.dumps([1]))
+    with pytest.raises(ValueError):
+        meta.GenericBuildMeta(path=metapath)
+
+
+def test_init_unknown_build(tmpdir):
+    """
+    Verify a build missing from builds.json is rejected.
+    """
+    with pytest.raises(ValueError):
+        meta.GenericBuildMeta(_create_test_files(tmpdir), '9.9.9')
+
+
+def test_register_artifact(tmpdir):
+    """
+    Verify registering computes the sha256 and size from the file.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    metadir = os.path.join(tmpdir, 'builds', '1.2.3', get_basearch())
+    with open(os.path.join(metadir, 'test.vmdk'), 'wb') as f:
+        f.write(b'vmdk')
+    m.register_artifact('aws', os.path.join(metadir, 'test.vmdk'))
+    assert m.get('images', 'aws') == {
+        'path': 'test.vmdk',
+        'sha256': hashlib.sha256(b'vmdk').hexdigest(),
+        'size': len(b'vmdk'),
+    }
+    assert m.verify_artifacts() == []
+
+
+def test_verify_artifacts_truncated(tmpdir):
+    """
+    Verify a truncated artifact is reported as a size and sha256 mismatch.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    metadir = os.path.join(tmpdir, 'builds', '1.2.3', get_basearch())
+    with open(os.path.join(metadir, 'test.qcow2'), 'r+b') as f:
+        f.truncate(2)
+    assert m.verify_artifacts() == [
+        'images.qemu: size 5 does not match 2 on disk',
+        'images.qemu: sha256 does not match test.qcow2',
+    ]
+
+
+def test_verify_artifacts_size(tmpdir):
+    """
+    Verify a recorded size that drifted from the file is reported.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    m['images']['qemu']['size'] = 42
+    assert m.verify_artifacts() == [
+        'images.qemu: size 42 does not match 5 on disk',
+    ]
+
+
+def test_register_artifact_outside(tmpdir):
+    """
+    Verify registering a file outside the build directory fails.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    with pytest.raises(Exception):
+        m.register_artifact('aws', os.path.join(tmpdir, 'builds', 'builds.json'))
+    assert 'aws' not in m['images']
+
+
+def test_verify_artifacts_images_type(tmpdir):
+    """
+    Verify a non-object images value is reported rather than raising.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    m['images'] = []
+    assert m.verify_artifacts() == ['images must be an object']
+    assert m.validate() == ['images must be an object']
+
+
+def test_register_artifact_stale_images(tmpdir):
+    """
+    Verify registering into a meta.json carried over from a previous build
+    only verifies cleanly once the stale images are dropped, as cmd-build
+    does.
+    """
+    m = meta.GenericBuildMeta(_create_validate_files(tmpdir), '1.2.3')
+    metadir = os.path.join(tmpdir, 'builds', '1.2.3', get_basearch())
+    with open(os.path.join(metadir, 'test.tar'), 'wb') as f:
+        f.write(b'ostree')
+    m['images']['ostree'] = {'path': 'old.tar', 'sha256': 'old', 'size': 1}
+    m['images']['metal'] = {'path': 'old.raw'}
+    m.write()
+
+    m.register_artifact('ostree', 'test.tar')
+    assert m.verify_artifacts() == [
+        'images.metal: old.raw does not exist',
+    ]
+
+    m.read()
+    del m['images']
+    m.register_artifact('ostree', 'test.tar')
+    assert list(m['images'].keys()) == ['ostree']
+    assert m.verify_artifacts() == []